@@ -59,9 +59,10 @@ func (c *GenericCommand) commandNode() {
 }
 
 type ControlCommand struct {
-	Name  string
-	Test  Test
-	Block []Command
+	Name      string
+	Arguments []Argument
+	Test      Test
+	Block     []Command
 }
 
 func (c *ControlCommand) String() string {
@@ -187,13 +188,14 @@ func (c *TagArgument) argumentNode() {
 }
 
 type StringArgument struct {
-	Value []string
+	Value     []string
+	Variables bool // true if any value contains a ${...} variable reference
 }
 
 func (a *StringArgument) String() string {
-	switch len(a) {
+	switch len(a.Value) {
 	case 1:
-		return a[0]
+		return a.Value[0]
 	default:
 		var buffer bytes.Buffer
 		buffer.WriteString("[")
@@ -232,6 +234,10 @@ func stringDepth(c Command, d int) string {
 	switch v := c.(type) {
 	case *ControlCommand:
 		buffer.WriteString(v.Name)
+		for _, a := range v.Arguments {
+			buffer.WriteString(" ")
+			buffer.WriteString(a.String())
+		}
 		if v.Test != nil {
 			buffer.WriteString(" ")
 			buffer.WriteString(v.Test.String())
@@ -10,7 +10,7 @@ func Equals(a, b Node) bool {
 	case *ControlCommand:
 		switch bv := b.(type) {
 		case *ControlCommand:
-			return av.Name == bv.Name && Equals(av.Test, bv.Test) && equalsCommands(av.Block, bv.Block)
+			return av.Name == bv.Name && equalsArguments(av.Arguments, bv.Arguments) && Equals(av.Test, bv.Test) && equalsCommands(av.Block, bv.Block)
 		}
 	case *StopCommand:
 		if _, ok := b.(*StopCommand); ok {
@@ -54,24 +54,24 @@ func Equals(a, b Node) bool {
 		case *GenericTest:
 			return av.Name == bv.Name && equalsArguments(av.Arguments, bv.Arguments)
 		}
-	case NumberArgument:
+	case *NumberArgument:
 		switch bv := b.(type) {
-		case NumberArgument:
-			return string(av) == string(bv)
+		case *NumberArgument:
+			return av.Value == bv.Value
 		}
-	case TagArgument:
+	case *TagArgument:
 		switch bv := b.(type) {
-		case TagArgument:
-			return string(av) == string(bv)
+		case *TagArgument:
+			return av.Value == bv.Value
 		}
-	case StringArgument:
+	case *StringArgument:
 		switch bv := b.(type) {
-		case StringArgument:
-			if len(av) != len(bv) {
+		case *StringArgument:
+			if len(av.Value) != len(bv.Value) {
 				return false
 			}
-			for i := range av {
-				if av[i] != bv[i] {
+			for i := range av.Value {
+				if av.Value[i] != bv.Value[i] {
 					return false
 				}
 			}
@@ -0,0 +1,232 @@
+package optimize
+
+import (
+	"strings"
+
+	"github.com/qingshan/sieve/ast"
+)
+
+// File returns a simplified version of f with the same semantics:
+// constant tests are folded, unreachable blocks and commands after stop
+// are removed and adjacent header tests inside anyof are merged. Tests
+// that may still be evaluated are never dropped, since some of them
+// (such as :matches) set variables as a side effect. f is not modified,
+// but nodes that are not changed, such as generic commands, generic
+// tests and arguments, are shared between f and the result.
+func File(f *ast.File) *ast.File {
+	return &ast.File{f.Name, commands(f.List)}
+}
+
+// link is one if, elsif or else of a conditional chain; test is nil for else.
+type link struct {
+	test  ast.Test
+	block []ast.Command
+}
+
+// commands optimizes a command list, dropping everything after a stop.
+func commands(cl []ast.Command) []ast.Command {
+	var out []ast.Command
+	for i := 0; i < len(cl); i++ {
+		switch c := cl[i].(type) {
+		case *ast.ControlCommand:
+			if c.Name != "if" {
+				out = append(out, &ast.ControlCommand{c.Name, c.Arguments, c.Test, commands(c.Block)})
+				break
+			}
+			links := []link{{c.Test, c.Block}}
+			var comments []ast.Command
+			for i+1 < len(cl) {
+				var next ast.Command
+				j := i + 1
+				for ; j < len(cl); j++ {
+					if _, ok := cl[j].(*ast.CommentCommand); !ok {
+						next = cl[j]
+						break
+					}
+				}
+				cc, ok := next.(*ast.ControlCommand)
+				if !ok || (cc.Name != "elsif" && cc.Name != "else") {
+					break
+				}
+				comments = append(comments, cl[i+1:j]...)
+				links = append(links, link{cc.Test, cc.Block})
+				i = j
+			}
+			out = append(out, comments...)
+			out = append(out, chain(links)...)
+		default:
+			out = append(out, c)
+		}
+		if len(out) > 0 {
+			if _, ok := out[len(out)-1].(*ast.StopCommand); ok {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// chain folds the tests of an if/elsif/else chain and drops the links
+// that can never run. A leading link whose test is always true is
+// replaced by its block.
+func chain(links []link) []ast.Command {
+	var kept []link
+	for _, l := range links {
+		var t ast.Test = &ast.TrueTest{}
+		if l.test != nil {
+			t = test(l.test)
+		}
+		switch t.(type) {
+		case *ast.FalseTest:
+			continue
+		case *ast.TrueTest:
+			if len(kept) == 0 {
+				return commands(l.block)
+			}
+			kept = append(kept, link{nil, l.block})
+			return rebuild(kept)
+		}
+		kept = append(kept, link{t, l.block})
+	}
+	return rebuild(kept)
+}
+
+// rebuild turns the remaining links back into if/elsif/else commands.
+func rebuild(links []link) []ast.Command {
+	var out []ast.Command
+	for i, l := range links {
+		name := "elsif"
+		switch {
+		case i == 0:
+			name = "if"
+		case l.test == nil:
+			name = "else"
+		}
+		out = append(out, &ast.ControlCommand{name, nil, l.test, commands(l.block)})
+	}
+	return out
+}
+
+// test folds the constant operands of not, allof and anyof.
+func test(t ast.Test) ast.Test {
+	switch v := t.(type) {
+	case *ast.NotTest:
+		switch n := test(v.Test).(type) {
+		case *ast.TrueTest:
+			return &ast.FalseTest{}
+		case *ast.FalseTest:
+			return &ast.TrueTest{}
+		case *ast.NotTest:
+			return n.Test
+		default:
+			return &ast.NotTest{n}
+		}
+	case *ast.AllofTest:
+		tl := tests(v.Tests, true)
+		switch {
+		case len(tl) == 0:
+			return &ast.TrueTest{}
+		case len(tl) == 1:
+			return tl[0]
+		}
+		return &ast.AllofTest{tl}
+	case *ast.AnyofTest:
+		tl := mergeHeaders(tests(v.Tests, false))
+		switch {
+		case len(tl) == 0:
+			return &ast.FalseTest{}
+		case len(tl) == 1:
+			return tl[0]
+		}
+		return &ast.AnyofTest{tl}
+	}
+	return t
+}
+
+// tests folds the operands of allof (neutral true) or anyof (neutral
+// false). Operands equal to neutral are dropped and everything after an
+// operand equal to !neutral is cut, since evaluation stops there.
+func tests(tl []ast.Test, neutral bool) []ast.Test {
+	var out []ast.Test
+	for _, t := range tl {
+		t = test(t)
+		switch t.(type) {
+		case *ast.TrueTest:
+			if neutral {
+				continue
+			}
+			return append(out, t)
+		case *ast.FalseTest:
+			if !neutral {
+				continue
+			}
+			return append(out, t)
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// mergeHeaders merges adjacent header tests of an anyof that differ only
+// in their key list. Tests using :matches or :regex are left alone since
+// which of them matched decides the match variables.
+func mergeHeaders(tl []ast.Test) []ast.Test {
+	var out []ast.Test
+	for _, t := range tl {
+		if len(out) > 0 {
+			if merged := mergeHeader(out[len(out)-1], t); merged != nil {
+				out[len(out)-1] = merged
+				continue
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func mergeHeader(a, b ast.Test) ast.Test {
+	at, ok := a.(*ast.GenericTest)
+	if !ok || at.Name != "header" || !mergeable(at.Arguments) {
+		return nil
+	}
+	bt, ok := b.(*ast.GenericTest)
+	if !ok || bt.Name != "header" || !mergeable(bt.Arguments) {
+		return nil
+	}
+	n := len(at.Arguments)
+	if len(bt.Arguments) != n {
+		return nil
+	}
+	for i := 0; i < n-1; i++ {
+		if !ast.Equals(at.Arguments[i], bt.Arguments[i]) {
+			return nil
+		}
+	}
+	ak := at.Arguments[n-1].(*ast.StringArgument)
+	bk := bt.Arguments[n-1].(*ast.StringArgument)
+	keys := &ast.StringArgument{
+		Value:     append(append([]string{}, ak.Value...), bk.Value...),
+		Variables: ak.Variables || bk.Variables,
+	}
+	al := append(append([]ast.Argument{}, at.Arguments[:n-1]...), keys)
+	return &ast.GenericTest{at.Name, al}
+}
+
+// mergeable reports whether a header test's arguments end in a header
+// list and a key list and do not capture match variables.
+func mergeable(al []ast.Argument) bool {
+	if len(al) < 2 {
+		return false
+	}
+	for _, a := range al[len(al)-2:] {
+		if _, ok := a.(*ast.StringArgument); !ok {
+			return false
+		}
+	}
+	for _, a := range al {
+		if t, ok := a.(*ast.TagArgument); ok && (strings.EqualFold(t.Value, ":matches") || strings.EqualFold(t.Value, ":regex")) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package optimize
+
+import (
+	"testing"
+
+	"github.com/qingshan/sieve/ast"
+	"github.com/qingshan/sieve/parse"
+)
+
+func TestFile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`if true { keep; } else { discard; }`,
+			`keep;`,
+		},
+		{
+			`if false { keep; } elsif not not exists "X" { discard; } elsif allof (true, true) { stop; } else { keep; }`,
+			`if exists "X" { discard; } else { stop; }`,
+		},
+		{
+			`if allof (true, exists "X", false, exists "Y") { keep; }`,
+			`if allof (exists "X", false) { keep; }`,
+		},
+		{
+			`if anyof (false, not true) { keep; } discard;`,
+			`discard;`,
+		},
+		{
+			`keep; if true { stop; } discard; stop; keep;`,
+			`keep; stop;`,
+		},
+		{
+			`if exists "X" { keep; stop; fileinto "Y"; } discard;`,
+			`if exists "X" { keep; stop; } discard;`,
+		},
+		{
+			`if anyof (header :is "Subject" "a", header :is "Subject" ["b", "c"], header :is "From" "d") { discard; }`,
+			`if anyof (header :is "Subject" ["a", "b", "c"], header :is "From" "d") { discard; }`,
+		},
+		{
+			`if anyof (header :matches "Subject" "a*", header :matches "Subject" "b*") { discard; }`,
+			`if anyof (header :matches "Subject" "a*", header :matches "Subject" "b*") { discard; }`,
+		},
+		{
+			`if allof (header :is "Subject" "a", header :is "Subject" "b") { discard; }`,
+			`if allof (header :is "Subject" "a", header :is "Subject" "b") { discard; }`,
+		},
+	}
+	for _, test := range tests {
+		p := parse.Parse("TestFile", test.input)
+		output := File(&ast.File{"TestFile", p.List})
+		p = parse.Parse("TestFile", test.expected)
+		expected := &ast.File{"TestFile", p.List}
+		if !ast.Equals(output, expected) {
+			t.Errorf("\nInput:\n%s\n\nExpected:\n%s\n\nGot:\n%s\n", test.input, expected.String(), output.String())
+		}
+	}
+}
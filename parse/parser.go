@@ -1,5 +1,6 @@
 package parse
 import (
+	"bytes"
 	"strings"
 	"fmt"
 	"os"
@@ -9,13 +10,14 @@ import (
 
 // parser holds the state of the scanner.
 type Parser struct {
-	name      string        // the name of the input; used only for error reports
-	input     string        // the string being scanned
-	pos       int           // the position of token in Items; pos == -1 when Items is nil
-	Items     []Token       // the unreduced items received from the lexer
-	lastToken Token         // Used for error and debug messages
-	Lexer     *Lexer        // the lexer
-	List      []ast.Command // the file being parsed
+	name         string          // the name of the input; used only for error reports
+	input        string          // the string being scanned
+	pos          int             // the position of token in Items; pos == -1 when Items is nil
+	Items        []Token         // the unreduced items received from the lexer
+	lastToken    Token           // Used for error and debug messages
+	Lexer        *Lexer          // the lexer
+	List         []ast.Command   // the file being parsed
+	capabilities map[string]bool // the capabilities named by require commands so far
 }
 
 // ------------------------------------------------------------------------------
@@ -33,7 +35,7 @@ func (p *Parser) next() Token {
 	}
 	// call p.errorf if lexing error
 	if p.Items[p.pos].Typ == ERROR {
-		p.errorf(p.Items[p.pos].String())
+		p.errorf("%s", p.Items[p.pos])
 	}
 	p.lastToken = p.Items[p.pos]
 	return p.Items[p.pos]
@@ -121,10 +123,11 @@ func (p *Parser) errorf(format string, args ...interface{}) {
 func Parse(name, input string) *Parser {
 	l := Lex(name, input)
 	p := &Parser{
-		name:     name,
-		input:    input,
-		pos:      -1,
-		Lexer:    l,
+		name:         name,
+		input:        input,
+		pos:          -1,
+		Lexer:        l,
+		capabilities: make(map[string]bool),
 	}
 	p.run()
 	return p
@@ -148,15 +151,17 @@ func (p *Parser) run() {
 // Mutually recursive functions
 
 func parseFile(p *Parser) {
-	switch t := p.next(); {
-	case t.IsCommand() || t.Typ == LINECOMMENT || t.Typ == BLOCKCOMMENT || t.Typ == IDENTIFIER:
-		p.backup()
-		command := parseCommand(p)
-		p.List = append(p.List, command)
-	case t.Typ == EOF:
-		return
-	default:
-		p.errorf("Invalid statement at line %d:%d with token '%s' in file : %s\n", p.lineNumber(), t.Pos, t.Val, p.name)
+	for {
+		switch t := p.next(); {
+		case t.IsCommand() || t.Typ == LINECOMMENT || t.Typ == BLOCKCOMMENT || t.Typ == IDENTIFIER:
+			p.backup()
+			command := parseCommand(p)
+			p.List = append(p.List, command)
+		case t.Typ == EOF:
+			return
+		default:
+			p.errorf("Invalid statement at line %d:%d with token '%s' in file : %s\n", p.lineNumber(), t.Pos, t.Val, p.name)
+		}
 	}
 }
 
@@ -169,7 +174,7 @@ func parseCommand(p *Parser) ast.Command {
 			test = parseTest(p)
 		}
 		block := parseBlock(p)
-		return &ast.ControlCommand{name, test, block}
+		return &ast.ControlCommand{name, nil, test, block}
 	case t.Typ == LINECOMMENT:
 		text := t.Val
 		return &ast.CommentCommand{"line", text}
@@ -185,10 +190,19 @@ func parseCommand(p *Parser) ast.Command {
 	case t.Typ == IDENTIFIER:
 		name := t.Val
 		al := parseArguments(p)
+		// block commands such as foreverypart take arguments and a block
+		if p.accept([]TokenType{LEFTCURLY}) {
+			p.backup()
+			block := parseBlock(p)
+			return &ast.ControlCommand{name, al, nil, block}
+		}
 		if !p.accept([]TokenType{SEMICOLON}) {
 			p.errorf("Invalid command at line %d:%d with token '%s' in file : %s\n", p.lineNumber(), t.Pos, t.Val, p.name)
 			return nil
 		}
+		if strings.EqualFold(name, "require") {
+			p.require(al)
+		}
 		return &ast.GenericCommand{name, al}
 	default:
 		p.errorf("Invalid command at line %d:%d with token '%s' in file : %s\n", p.lineNumber(), t.Pos, t.Val, p.name)
@@ -267,19 +281,19 @@ func parseArguments(p *Parser) []ast.Argument {
 	for {
 		switch t := p.next(); {
 		case t.Typ == NUMBER:
-			al = append(al, ast.NumberArgument(t.Val))
+			al = append(al, &ast.NumberArgument{t.Val})
 		case t.Typ == TAG:
-			al = append(al, ast.TagArgument(t.Val))
+			al = append(al, &ast.TagArgument{t.Val})
 		case t.Typ == STRING:
-			s, err := strconv.Unquote(t.Val)
+			s, err := p.unquote(t)
 			if err != nil {
-				p.errorf(err.Error())
+				p.errorf("%s", err)
 				return al;
 			}
-			al = append(al, ast.StringArgument([]string{s}))
+			al = append(al, newStringArgument([]string{s}))
 		case t.Typ == LEFTBRACKET:
 			p.backup()
-			al = append(al, ast.StringArgument(parseStrings(p)))
+			al = append(al, newStringArgument(parseStrings(p)))
 		case atTerminator(t):
 			p.backup()
 			break Loop
@@ -303,9 +317,9 @@ func parseStrings(p *Parser) []string {
 	for {
 		switch t := p.next(); {
 		case t.Typ == STRING:
-			s, err := strconv.Unquote(t.Val)
+			s, err := p.unquote(t)
 			if err != nil {
-				p.errorf(err.Error())
+				p.errorf("%s", err)
 				return sl;
 			}
 			sl = append(sl, s)
@@ -325,8 +339,179 @@ func parseStrings(p *Parser) []string {
 }
 
 func atTerminator(t Token) bool {
-	if t.Typ == SEMICOLON || t.Typ == COMMA || t.Typ == LEFTCURLY || t.Typ == EOF {
+	if t.Typ == SEMICOLON || t.Typ == COMMA || t.Typ == RIGHTPAREN || t.Typ == LEFTCURLY || t.Typ == EOF {
 		return true
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// require records the capabilities named by a require command.
+func (p *Parser) require(al []ast.Argument) {
+	for _, a := range al {
+		if sa, ok := a.(*ast.StringArgument); ok {
+			for _, s := range sa.Value {
+				p.capabilities[s] = true
+			}
+		}
+	}
+}
+
+// unquote returns the value of the string token t, decoding encoded
+// characters once the script has required "encoded-character".
+func (p *Parser) unquote(t Token) (string, error) {
+	s, err := strconv.Unquote(t.Val)
+	if err != nil || !p.capabilities["encoded-character"] {
+		return s, err
+	}
+	s, err = decodeEncodedCharacters(s)
+	if err != nil {
+		return "", fmt.Errorf("Invalid encoded character at line %d:%d with token '%s' in file : %s: %v\n", p.lineNumber(), t.Pos, t.Val, p.name, err)
+	}
+	return s, nil
+}
+
+// newStringArgument builds a string argument, marking it when any of its
+// values references a variable so constant strings can skip expansion.
+func newStringArgument(sl []string) *ast.StringArgument {
+	a := &ast.StringArgument{Value: sl}
+	for _, s := range sl {
+		if hasVariables(s) {
+			a.Variables = true
+			break
+		}
+	}
+	return a
+}
+
+// hasVariables reports whether s contains a variable reference as
+// defined in RFC 5229: "${" [namespace "."] name "}" or "${" digits "}".
+func hasVariables(s string) bool {
+	for i := strings.Index(s, "${"); i >= 0; i = strings.Index(s, "${") {
+		s = s[i + 2:]
+		if n := scanVariableName(s); n > 0 && n < len(s) && s[n] == '}' {
+			return true
+		}
+	}
+	return false
+}
+
+// scanVariableName returns the length of the variable name at the start
+// of s, or 0 if s does not start with one. A namespace starts with an
+// identifier; the parts after each "." may be identifiers or numbers.
+func scanVariableName(s string) int {
+	n := scanDigits(s)
+	if n > 0 {
+		return n
+	}
+	n = scanIdentifier(s)
+	for n > 0 && n < len(s) && s[n] == '.' {
+		m := scanDigits(s[n + 1:])
+		if m == 0 {
+			m = scanIdentifier(s[n + 1:])
+		}
+		if m == 0 {
+			return 0
+		}
+		n += 1 + m
+	}
+	return n
+}
+
+// scanDigits returns the length of the run of digits at the start of s.
+func scanDigits(s string) int {
+	n := 0
+	for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// scanIdentifier returns the length of the identifier at the start of s,
+// or 0 if s does not start with one.
+func scanIdentifier(s string) int {
+	if len(s) == 0 || !(isLetter(s[0]) || s[0] == '_') {
+		return 0
+	}
+	n := 1
+	for n < len(s) && (isLetter(s[n]) || s[n] == '_' || '0' <= s[n] && s[n] <= '9') {
+		n++
+	}
+	return n
+}
+
+// isLetter reports whether c is an ASCII letter.
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// decodeEncodedCharacters replaces the "${hex:...}" and "${unicode:...}"
+// sequences of RFC 5228 section 2.4.2.4 in s. Malformed sequences are
+// left as they are.
+func decodeEncodedCharacters(s string) (string, error) {
+	var buffer bytes.Buffer
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		buffer.WriteString(s[:i])
+		s = s[i:]
+		j := strings.IndexByte(s, '}')
+		if j < 0 {
+			break
+		}
+		decoded, ok, err := decodeEncodedCharacter(s[2:j])
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			buffer.WriteString("${")
+			s = s[2:]
+			continue
+		}
+		buffer.WriteString(decoded)
+		s = s[j + 1:]
+	}
+	buffer.WriteString(s)
+	return buffer.String(), nil
+}
+
+// decodeEncodedCharacter decodes the text between "${" and "}". ok is
+// false if the text is not an encoded character.
+func decodeEncodedCharacter(text string) (decoded string, ok bool, err error) {
+	i := strings.IndexByte(text, ':')
+	if i < 0 {
+		return "", false, nil
+	}
+	kind := strings.ToLower(text[:i])
+	if kind != "hex" && kind != "unicode" {
+		return "", false, nil
+	}
+	fields := strings.FieldsFunc(text[i + 1:], func(r rune) bool {
+		return isSpace(r) || isEndOfLine(r)
+	})
+	if len(fields) == 0 {
+		return "", false, nil
+	}
+	var buffer bytes.Buffer
+	for _, f := range fields {
+		if strings.TrimLeft(f, "0123456789abcdefABCDEF") != "" {
+			return "", false, nil
+		}
+		switch kind {
+		case "hex":
+			if len(f) > 2 {
+				return "", false, nil
+			}
+			b, _ := strconv.ParseUint(f, 16, 8)
+			buffer.WriteByte(byte(b))
+		case "unicode":
+			r, err := strconv.ParseUint(f, 16, 32)
+			if err != nil || r > 0x10FFFF || 0xD800 <= r && r <= 0xDFFF {
+				return "", false, fmt.Errorf("unicode character %s out of range", f)
+			}
+			buffer.WriteRune(rune(r))
+		}
+	}
+	return buffer.String(), true, nil
+}
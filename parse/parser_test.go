@@ -12,9 +12,9 @@ func TestGenericCommand(t *testing.T) {
 	file := &ast.File{p.name, p.List}
 	commandList := []ast.Command{
 		&ast.GenericCommand{"header", []ast.Argument{
-			ast.TagArgument(":contains"),
-			ast.StringArgument([]string{"Subject"}),
-			ast.StringArgument([]string{"subject keyword"}),
+			&ast.TagArgument{":contains"},
+			&ast.StringArgument{Value: []string{"Subject"}},
+			&ast.StringArgument{Value: []string{"subject keyword"}},
 		}},
 	}
 	expected := &ast.File{
@@ -36,16 +36,16 @@ func TestIfControlCommand(t *testing.T) {
 			Name: "if",
 			Test: &ast.GenericTest{"header",
 				[]ast.Argument{
-					ast.TagArgument(":contains"),
-					ast.StringArgument([]string{"Subject"}),
-					ast.StringArgument([]string{"keyword1", "keyword2"}),
+					&ast.TagArgument{":contains"},
+					&ast.StringArgument{Value: []string{"Subject"}},
+					&ast.StringArgument{Value: []string{"keyword1", "keyword2"}},
 				}},
 			Block: []ast.Command{
 				&ast.GenericCommand{
 					"discard",
 					[]ast.Argument{
-						ast.TagArgument(":under"),
-						ast.StringArgument([]string{"test"}),
+						&ast.TagArgument{":under"},
+						&ast.StringArgument{Value: []string{"test"}},
 					}},
 				&ast.StopCommand{},
 			},
@@ -70,16 +70,16 @@ func TestIfElseControlCommand(t *testing.T) {
 			Name: "if",
 			Test: &ast.GenericTest{"header",
 				[]ast.Argument{
-					ast.TagArgument(":contains"),
-					ast.StringArgument([]string{"Subject"}),
-					ast.StringArgument([]string{"keyword1", "keyword2"}),
+					&ast.TagArgument{":contains"},
+					&ast.StringArgument{Value: []string{"Subject"}},
+					&ast.StringArgument{Value: []string{"keyword1", "keyword2"}},
 				}},
 			Block: []ast.Command{
 				&ast.GenericCommand{
 					"discard",
 					[]ast.Argument{
-						ast.TagArgument(":under"),
-						ast.StringArgument([]string{"test"}),
+						&ast.TagArgument{":under"},
+						&ast.StringArgument{Value: []string{"test"}},
 					}},
 				&ast.StopCommand{},
 			},
@@ -110,16 +110,16 @@ func TestIfElsifElseControlCommand(t *testing.T) {
 			Name: "if",
 			Test: &ast.GenericTest{"header",
 				[]ast.Argument{
-					ast.TagArgument(":contains"),
-					ast.StringArgument([]string{"Subject"}),
-					ast.StringArgument([]string{"keyword1", "keyword2"}),
+					&ast.TagArgument{":contains"},
+					&ast.StringArgument{Value: []string{"Subject"}},
+					&ast.StringArgument{Value: []string{"keyword1", "keyword2"}},
 				}},
 			Block: []ast.Command{
 				&ast.GenericCommand{
 					"discard",
 					[]ast.Argument{
-						ast.TagArgument(":under"),
-						ast.StringArgument([]string{"test"}),
+						&ast.TagArgument{":under"},
+						&ast.StringArgument{Value: []string{"test"}},
 					}},
 				&ast.StopCommand{},
 			},
@@ -139,10 +139,138 @@ func TestIfElsifElseControlCommand(t *testing.T) {
 		},
 	}
 	expected := &ast.File{
+		Name: p.name,
 		List: commandList,
 	}
 	if !ast.Equals(file, expected) {
 		// t.Errorf("\nExpected:\n%s\n\nGot:\n%s\n", spew.Sdump(expected), spew.Sdump(output))
 		t.Errorf("\nExpected:\n%s\n\nGot:\n%s\n", expected.String(), file.String())
 	}
-}
\ No newline at end of file
+}
+
+func TestStringVariables(t *testing.T) {
+	input := `set "a" ["${from}", "${"]; set "b" "${1}"; set "c" ["$1", "${.x}", "${a.}", "${1.a}"]; set "d" "${a.1}"; set "e" "${a.1.b}";`
+	p := Parse("TestStringVariables", input)
+	expected := []bool{false, true, false, true, false, false, false, true, false, true}
+	var output []bool
+	for _, c := range p.List {
+		for _, a := range c.(*ast.GenericCommand).Arguments {
+			output = append(output, a.(*ast.StringArgument).Variables)
+		}
+	}
+	if len(output) != len(expected) {
+		t.Errorf("\nExpected: %v\n Got:     %v\n", expected, output)
+		return
+	}
+	for i := range output {
+		if output[i] != expected[i] {
+			t.Errorf("\nExpected: %v\n Got:     %v\n", expected, output)
+		}
+	}
+}
+
+func TestForeverypartControlCommand(t *testing.T) {
+	input := `foreverypart :name "outer" { if header :mime :subtype "Content-Type" "exe" { discard; break :name "outer"; } }`
+	p := Parse("TestForeverypartControlCommand", input)
+	file := &ast.File{p.name, p.List}
+	commandList := []ast.Command{
+		&ast.ControlCommand{
+			Name: "foreverypart",
+			Arguments: []ast.Argument{
+				&ast.TagArgument{":name"},
+				&ast.StringArgument{Value: []string{"outer"}},
+			},
+			Block: []ast.Command{
+				&ast.ControlCommand{
+					Name: "if",
+					Test: &ast.GenericTest{"header",
+						[]ast.Argument{
+							&ast.TagArgument{":mime"},
+							&ast.TagArgument{":subtype"},
+							&ast.StringArgument{Value: []string{"Content-Type"}},
+							&ast.StringArgument{Value: []string{"exe"}},
+						}},
+					Block: []ast.Command{
+						&ast.GenericCommand{"discard", nil},
+						&ast.GenericCommand{
+							"break",
+							[]ast.Argument{
+								&ast.TagArgument{":name"},
+								&ast.StringArgument{Value: []string{"outer"}},
+							}},
+					},
+				},
+			},
+		},
+	}
+	expected := &ast.File{
+		Name: p.name,
+		List: commandList,
+	}
+	if !ast.Equals(file, expected) {
+		t.Errorf("\nExpected:\n%s\n\nGot:\n%s\n", expected.String(), file.String())
+	}
+}
+
+func TestEncodedCharacters(t *testing.T) {
+	input := `require "encoded-character"; fileinto ["${hex:49 4e 42 4F 58}.${UNICODE:1f600}", "${hex:4142}${unicode:}$${hex:24}"];`
+	p := Parse("TestEncodedCharacters", input)
+	expected := []string{"INBOX.\U0001F600", "${hex:4142}${unicode:}$$"}
+	output := p.List[1].(*ast.GenericCommand).Arguments[0].(*ast.StringArgument).Value
+	if len(output) != len(expected) || output[0] != expected[0] || output[1] != expected[1] {
+		t.Errorf("\nExpected: %q\n Got:     %q\n", expected, output)
+	}
+
+	input = `fileinto "${hex:41}";`
+	p = Parse("TestEncodedCharacters", input)
+	output = p.List[0].(*ast.GenericCommand).Arguments[0].(*ast.StringArgument).Value
+	if output[0] != "${hex:41}" {
+		t.Errorf("\nExpected: %q\n Got:     %q\n", "${hex:41}", output[0])
+	}
+
+	input = `REQUIRE "encoded-character"; fileinto "${hex:41}";`
+	p = Parse("TestEncodedCharacters", input)
+	output = p.List[1].(*ast.GenericCommand).Arguments[0].(*ast.StringArgument).Value
+	if output[0] != "A" {
+		t.Errorf("\nExpected: %q\n Got:     %q\n", "A", output[0])
+	}
+
+	for _, s := range []string{"${unicode:D800}", "${unicode:110000}", "${unicode:100000000}"} {
+		if _, err := decodeEncodedCharacters(s); err == nil {
+			t.Errorf("Expected an error decoding %q", s)
+		}
+	}
+}
+
+func TestAnyofGenericTests(t *testing.T) {
+	input := `if anyof (exists "X", header :is "a" "b") { keep; }`
+	p := Parse("TestAnyofGenericTests", input)
+	file := &ast.File{p.name, p.List}
+	commandList := []ast.Command{
+		&ast.ControlCommand{
+			Name: "if",
+			Test: &ast.AnyofTest{[]ast.Test{
+				&ast.GenericTest{"exists",
+					[]ast.Argument{
+						&ast.StringArgument{Value: []string{"X"}},
+					}},
+				&ast.GenericTest{"header",
+					[]ast.Argument{
+						&ast.TagArgument{":is"},
+						&ast.StringArgument{Value: []string{"a"}},
+						&ast.StringArgument{Value: []string{"b"}},
+					}},
+			}},
+			Block: []ast.Command{
+				&ast.GenericCommand{"keep", nil},
+			},
+		},
+	}
+	expected := &ast.File{
+		Name: p.name,
+		List: commandList,
+	}
+	if !ast.Equals(file, expected) {
+		t.Errorf("\nExpected:\n%s\n\nGot:\n%s\n", expected.String(), file.String())
+	}
+}